@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -11,10 +12,14 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/adrg/frontmatter"
+	"github.com/pelletier/go-toml/v2"
 	"gopkg.in/yaml.v3"
 )
 
@@ -42,19 +47,57 @@ func (r Datetime) MarshalYAML() (any, error) {
 	return r.Time.Format(datetimeFormat), nil
 }
 
+// MarshalText and UnmarshalText let the TOML encoder and decoder (which fall back to
+// encoding.TextMarshaler/TextUnmarshaler for scalars) write and read Datetime the same way
+// MarshalYAML/UnmarshalYAML do for YAML.
+func (r Datetime) MarshalText() ([]byte, error) {
+	return []byte(r.Time.Format(datetimeFormat)), nil
+}
+
+func (r *Datetime) UnmarshalText(b []byte) error {
+	tt, err := time.Parse(datetimeFormat, strings.TrimSpace(string(b)))
+	if err != nil {
+		r.Time = time.Time{}
+		return nil
+	}
+	r.Time = tt
+	return nil
+}
+
+// MarshalJSON and UnmarshalJSON give Datetime the same datetimeFormat encoding for JSON.
+// Without them, encoding/json prefers the embedded time.Time's own json.Marshaler over
+// MarshalText, silently producing RFC 3339 instead.
+func (r Datetime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Time.Format(datetimeFormat))
+}
+
+func (r *Datetime) UnmarshalJSON(b []byte) error {
+	var buf string
+	if err := json.Unmarshal(b, &buf); err != nil {
+		return nil
+	}
+	tt, err := time.Parse(datetimeFormat, strings.TrimSpace(buf))
+	if err != nil {
+		r.Time = time.Time{}
+		return nil
+	}
+	r.Time = tt
+	return nil
+}
+
 type MatterIn struct {
-	Created Datetime `yaml:"created"`
-	Tags    []string `yaml:"tags,omitempty"`
-	Aliases []string `yaml:"aliases,omitempty"`
-	Publish bool     `yaml:"publish,omitempty"`
+	Created Datetime `yaml:"created" toml:"created" json:"created"`
+	Tags    []string `yaml:"tags,omitempty" toml:"tags,omitempty" json:"tags,omitempty"`
+	Aliases []string `yaml:"aliases,omitempty" toml:"aliases,omitempty" json:"aliases,omitempty"`
+	Publish bool     `yaml:"publish,omitempty" toml:"publish,omitempty" json:"publish,omitempty"`
 }
 
 type MatterOut struct {
-	Created time.Time `yaml:"created"`
-	Lastmod time.Time `yaml:"lastmod"`
-	Title   string    `yaml:"title"`
-	Tags    []string  `yaml:"tags"`
-	Aliases []string  `yaml:"aliases"`
+	Created time.Time `yaml:"created" toml:"created" json:"created"`
+	Lastmod time.Time `yaml:"lastmod" toml:"lastmod" json:"lastmod"`
+	Title   string    `yaml:"title" toml:"title" json:"title"`
+	Tags    []string  `yaml:"tags" toml:"tags" json:"tags"`
+	Aliases []string  `yaml:"aliases" toml:"aliases" json:"aliases"`
 }
 
 func main() {
@@ -64,8 +107,11 @@ func main() {
 		panic(err)
 	}
 
-	var target string
+	var target, format string
 	var force, quartz, fixChtimeFromGit, reformat, debug, clean bool
+	var jobs, transcludeHeadingOffset int
+	excludes := stringSlice{"templates/**"}
+	copyOnly := stringSlice{"assets/**"}
 
 	flag.StringVar(&target, "target", wd, "Path to write changed files to.")
 	flag.BoolVar(&force, "force", false, "Execute all changes without asking.")
@@ -74,176 +120,514 @@ func main() {
 	flag.BoolVar(&quartz, "quartz", false, "Prepare frontmatter for Quartz publishing.")
 	flag.BoolVar(&reformat, "reformat", false, "Replace frontmatter with this tool format and fix ending newlines.")
 	flag.BoolVar(&fixChtimeFromGit, "git-chtime", false, "Change files chtime from git, useful right after git clone.")
+	flag.IntVar(&jobs, "jobs", runtime.NumCPU(), "Number of files to process concurrently.")
+	flag.Var(&excludes, "exclude", "Glob (repeatable), relative to the vault root, of paths to skip entirely; ** recurses through any number of path segments, e.g. '**/Daily/**'. Adds to the default templates/**.")
+	flag.Var(&copyOnly, "copy-only", "Glob (repeatable), relative to the vault root, of paths to copy through unprocessed; ** recurses through any number of path segments, e.g. 'static/**.pdf'. Adds to the default assets/**.")
+	flag.StringVar(&format, "format", "yaml", "Frontmatter encoding to write out: yaml, toml, or json.")
+	flag.IntVar(&transcludeHeadingOffset, "transclude-heading-offset", 1, "Levels to add to headings inside a transcluded note/section, so they nest under the including document's headings.")
 	flag.Parse()
 
+	switch format {
+	case "yaml", "toml", "json":
+	default:
+		panic(fmt.Sprintf("unknown -format %q: must be yaml, toml, or json", format))
+	}
+	if jobs < 1 {
+		panic(fmt.Sprintf("invalid -jobs %d: must be at least 1", jobs))
+	}
+
 	if target != wd && clean {
 		if err := os.RemoveAll(target); err != nil {
 			panic(err)
 		}
 	}
 
-	inputs := make(chan bool)
-	go func() {
-		scanner := bufio.NewScanner(os.Stdin)
-		for scanner.Scan() {
-			switch scanner.Text() {
-			case "y":
-				inputs <- true
-			default:
-				inputs <- false
-			}
+	ignorePatterns, err := readIgnoreFile(filepath.Join(wd, ".obsifixignore"))
+	if err != nil {
+		panic(err)
+	}
+	excludes = append(excludes, ignorePatterns...)
+
+	var noteIndex, assetIndex map[string]string
+	if quartz {
+		noteIndex, assetIndex, err = buildVaultIndex(wd, excludes, copyOnly)
+		if err != nil {
+			panic(err)
 		}
-	}()
+	}
 
-	if err := filepath.Walk(
-		wd,
-		func(fpath string, info fs.FileInfo, err error) error {
-			if fixChtimeFromGit {
-				gitTime, err := getGitLastMod(fpath)
-				if err != nil {
-					return err
-				}
-				if !gitTime.IsZero() && !gitTime.Equal(info.ModTime()) {
-					fmt.Printf("Changing chtime: %s\n", info.Name())
-					return os.Chtimes(fpath, gitTime, gitTime)
-				}
-				return nil
-			}
+	gitIdx, err := buildGitIndex(wd)
+	if err != nil {
+		if debug {
+			fmt.Printf("Could not build git log index, falling back to per-file lookups: %v\n", err)
+		}
+		gitIdx = nil
+	}
 
-			if info.IsDir() {
-				return nil
-			}
-			if !strings.HasSuffix(info.Name(), ".md") {
-				return nil
+	cfg := &config{
+		wd:               wd,
+		target:           target,
+		force:            force,
+		quartz:           quartz,
+		reformat:         reformat,
+		fixChtimeFromGit: fixChtimeFromGit,
+		debug:            debug,
+		noteIndex:        noteIndex,
+		assetIndex:       assetIndex,
+		gitIdx:           gitIdx,
+		excludes:         excludes,
+		copyOnly:         copyOnly,
+		format:           format,
+		headingOffset:    transcludeHeadingOffset,
+	}
+
+	if !force {
+		cfg.prompts = make(chan promptRequest)
+		go func() {
+			scanner := bufio.NewScanner(os.Stdin)
+			for req := range cfg.prompts {
+				fmt.Print(req.question)
+				scanner.Scan()
+				req.resp <- scanner.Text() == "y"
 			}
+		}()
+	}
 
-			contentPath := strings.TrimPrefix(fpath, wd)
-			if strings.HasPrefix(contentPath, "/templates") {
-				if debug {
-					fmt.Printf("Skipping processing file: %s\n", contentPath)
+	tasks := make(chan fileTask)
+	var wg sync.WaitGroup
+	var errsMu sync.Mutex
+	var errs []error
+
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tasks {
+				if err := processFile(cfg, t.fpath, t.info); err != nil {
+					errsMu.Lock()
+					errs = append(errs, fmt.Errorf("%s: %w", t.fpath, err))
+					errsMu.Unlock()
 				}
-				return nil
 			}
-			if strings.HasPrefix(contentPath, "/assets") {
-				fmt.Printf("Copying file: %s\n", contentPath)
-				return copy(fpath, path.Join(target, contentPath))
+		}()
+	}
+
+	walkErr := filepath.Walk(wd, func(fpath string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		tasks <- fileTask{fpath: fpath, info: info}
+		return nil
+	})
+	close(tasks)
+	wg.Wait()
+	if cfg.prompts != nil {
+		close(cfg.prompts)
+	}
+
+	if walkErr != nil {
+		panic(walkErr)
+	}
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+}
+
+// fileTask is a single path handed from the walker to a worker.
+type fileTask struct {
+	fpath string
+	info  fs.FileInfo
+}
+
+// promptRequest is a y/n question a worker wants answered, serialized through the single
+// goroutine that owns stdin.
+type promptRequest struct {
+	question string
+	resp     chan bool
+}
+
+// config bundles the flags and vault-wide indices every worker needs, since they're now
+// shared across goroutines instead of closed over by a single serial Walk callback.
+type config struct {
+	wd               string
+	target           string
+	force            bool
+	quartz           bool
+	reformat         bool
+	fixChtimeFromGit bool
+	debug            bool
+	noteIndex        map[string]string
+	assetIndex       map[string]string
+	gitIdx           *gitIndex
+	prompts          chan promptRequest
+	excludes         []string
+	copyOnly         []string
+	format           string
+	headingOffset    int
+}
+
+// stringSlice is a repeatable flag.Value, e.g. `-exclude a -exclude b`.
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// readIgnoreFile reads glob patterns, one per line (blank lines and `#` comments skipped),
+// from an .obsifixignore file; each is matched like an -exclude glob against the
+// vault-root-relative path. Gitignore's other rules are not applied: a leading `!`, a
+// leading `/` or a trailing `/` is taken literally, and a pattern without a `/` matches
+// only at the vault root. A missing file is not an error.
+func readIgnoreFile(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+
+	return patterns, nil
+}
+
+// matchAny reports whether relPath (vault-root-relative, no leading slash) matches any of
+// the given globs. `**` recurses through any number of path segments (including zero), so
+// "templates/**" also covers "templates/sub/a.md" and "**/Daily/**" covers
+// "A/B/Daily/C/D.md"; `*` and `?` are confined to a single segment.
+func matchAny(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		if globToRegexp(pattern).MatchString(relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	globRegexpMu    sync.Mutex
+	globRegexpCache = make(map[string]*regexp.Regexp)
+)
+
+// globToRegexp compiles a glob pattern into an anchored regexp, caching the result since
+// matchAny is called concurrently from the worker pool for every file. `*` becomes
+// `[^/]*` and `?` becomes `[^/]`. `**` as a whole path segment (a leading `**/`, trailing
+// `/**`, or interior `/**/`) makes the adjoining `/` optional too, so it can also match
+// zero intervening segments — e.g. `**/Daily/**` matches both `Daily/x.md` and
+// `a/Daily/x.md`. Any other `**` (e.g. `static/**.pdf`) is just a wildcard that happens to
+// cross `/` boundaries.
+func globToRegexp(pattern string) *regexp.Regexp {
+	globRegexpMu.Lock()
+	defer globRegexpMu.Unlock()
+
+	if re, ok := globRegexpCache[pattern]; ok {
+		return re
+	}
+
+	body := pattern
+	leadOptional := strings.HasPrefix(body, "**/")
+	if leadOptional {
+		body = strings.TrimPrefix(body, "**/")
+	}
+	trailOptional := strings.HasSuffix(body, "/**")
+	if trailOptional {
+		body = strings.TrimSuffix(body, "/**")
+	}
+
+	const interiorPlaceholder = "\x01"
+	const globPlaceholder = "\x02"
+	body = strings.ReplaceAll(body, "/**/", interiorPlaceholder)
+	body = strings.ReplaceAll(body, "**", globPlaceholder)
+
+	quoted := regexp.QuoteMeta(body)
+	quoted = strings.ReplaceAll(quoted, `\*`, "[^/]*")
+	quoted = strings.ReplaceAll(quoted, `\?`, "[^/]")
+	quoted = strings.ReplaceAll(quoted, interiorPlaceholder, "/(?:.*/)?")
+	quoted = strings.ReplaceAll(quoted, globPlaceholder, ".*")
+	if leadOptional {
+		quoted = "(?:.*/)?" + quoted
+	}
+	if trailOptional {
+		quoted = quoted + "(?:/.*)?"
+	}
+
+	re := regexp.MustCompile("^" + quoted + "$")
+	globRegexpCache[pattern] = re
+	return re
+}
+
+// confirm asks question on the shared prompt goroutine and blocks for the answer. Forced
+// runs never call this, so stdin is only ever touched from that one goroutine.
+func confirm(prompts chan promptRequest, question string) bool {
+	resp := make(chan bool, 1)
+	prompts <- promptRequest{question: question, resp: resp}
+	return <-resp
+}
+
+var printMu sync.Mutex
+
+// logf prints a progress line, synchronized so the worker pool's goroutines don't
+// interleave their output.
+func logf(format string, args ...any) {
+	printMu.Lock()
+	defer printMu.Unlock()
+	fmt.Printf(format, args...)
+}
+
+func processFile(cfg *config, fpath string, info fs.FileInfo) error {
+	if cfg.fixChtimeFromGit {
+		gitTime, err := cfg.gitIdx.getLastMod(strings.TrimPrefix(fpath, cfg.wd), fpath)
+		if err != nil {
+			return err
+		}
+		if !gitTime.IsZero() && !gitTime.Equal(info.ModTime()) {
+			logf("Changing chtime: %s\n", info.Name())
+			return os.Chtimes(fpath, gitTime, gitTime)
+		}
+		return nil
+	}
+
+	if info.IsDir() {
+		return nil
+	}
+
+	contentPath := strings.TrimPrefix(fpath, cfg.wd)
+	relPath := strings.TrimPrefix(contentPath, "/")
+	if matchAny(cfg.excludes, relPath) {
+		if cfg.debug {
+			logf("Skipping processing file: %s\n", contentPath)
+		}
+		return nil
+	}
+	if matchAny(cfg.copyOnly, relPath) {
+		logf("Copying file: %s\n", contentPath)
+		writeFpath := path.Join(cfg.target, contentPath)
+		if err := os.MkdirAll(filepath.Dir(writeFpath), 509); err != nil {
+			return err
+		}
+		return copy(fpath, writeFpath)
+	}
+	if !strings.HasSuffix(info.Name(), ".md") {
+		return nil
+	}
+	if cfg.debug {
+		logf("Processing file: %s\n", contentPath)
+	}
+
+	matterIn, content, err := getFrontMatterIn(fpath)
+	if err != nil {
+		return err
+	}
+
+	var matter any
+	var always bool
+	if cfg.reformat {
+		for i, tag := range matterIn.Tags {
+			if tag == "wip" {
+				matterIn.Tags[i] = "draft"
 			}
-			if debug {
-				fmt.Printf("Processing file: %s\n", contentPath)
+		}
+		created, err := cfg.gitIdx.getCreated(contentPath, fpath)
+		if err != nil {
+			return err
+		}
+		matterIn.Created = Datetime{created}
+
+		matter = matterIn
+		if cfg.force {
+			goto compareAndWrite
+		} else {
+			if confirm(cfg.prompts, fmt.Sprintf("Do you want to reformat file: %s (y/n)? ", contentPath)) {
+				goto compareAndWrite
 			}
-
-			matterIn, content, err := getFrontMatterIn(fpath)
-			if err != nil {
-				return err
+		}
+	} else if cfg.quartz {
+		if !matterIn.Publish {
+			logf("Not publishing: %s\n", contentPath)
+			return nil
+		}
+		for _, tag := range matterIn.Tags {
+			if tag == "draft" {
+				logf("Not publishing (due to draft tag): %s\n", contentPath)
+				return nil
 			}
+		}
+		content = rewriteWikilinks(content, cfg.wd, cfg.noteIndex, cfg.assetIndex, contentPath, cfg.debug, cfg.headingOffset)
 
-			var matter any
-			var always bool
-			if reformat {
-				for i, tag := range matterIn.Tags {
-					if tag == "wip" {
-						matterIn.Tags[i] = "draft"
-					}
-				}
-				created, err := getGitCreated(fpath)
-				if err != nil {
-					return err
-				}
-				matterIn.Created = Datetime{created}
-
-				matter = matterIn
-				if force {
-					goto compareAndWrite
-				} else {
-					fmt.Printf("Do you want to reformat file: %s (y/n)? ", contentPath)
-					if <-inputs {
-						goto compareAndWrite
-					}
-				}
-			} else if quartz {
-				if !matterIn.Publish {
-					fmt.Printf("Not publishing: %s\n", contentPath)
-					return nil
-				}
-				for _, tag := range matterIn.Tags {
-					if tag == "draft" {
-						fmt.Printf("Not publishing (due to draft tag): %s\n", contentPath)
-						return nil
-					}
-				}
-				// Fill Quartz-compatible frontmatter.
-				matterOut := MatterOut{
-					Title:   strings.TrimSuffix(info.Name(), ".md"),
-					Aliases: matterIn.Aliases,
-					Tags:    matterIn.Tags,
-				}
-				created, err := getGitCreated(fpath)
-				if err != nil {
-					return err
-				}
-				matterOut.Created = created
-				lastmod, err := getGitLastMod(fpath)
-				if err != nil {
-					return err
-				}
-				matterOut.Lastmod = lastmod
-				if contentPath == "/_index.md" {
-					matterOut.Title = "Index"
-				}
+		// Fill Quartz-compatible frontmatter.
+		matterOut := MatterOut{
+			Title:   strings.TrimSuffix(info.Name(), ".md"),
+			Aliases: matterIn.Aliases,
+			Tags:    matterIn.Tags,
+		}
+		created, err := cfg.gitIdx.getCreated(contentPath, fpath)
+		if err != nil {
+			return err
+		}
+		matterOut.Created = created
+		lastmod, err := cfg.gitIdx.getLastMod(contentPath, fpath)
+		if err != nil {
+			return err
+		}
+		matterOut.Lastmod = lastmod
+		if contentPath == "/_index.md" {
+			matterOut.Title = "Index"
+		}
 
-				matter = matterOut
-				always = true
-				if force {
-					goto compareAndWrite
-				} else {
-					fmt.Printf("Do you want to Quartz fix file: %s (y/n)? ", contentPath)
-					if <-inputs {
-						goto compareAndWrite
-					}
-				}
+		matter = matterOut
+		always = true
+		if cfg.force {
+			goto compareAndWrite
+		} else {
+			if confirm(cfg.prompts, fmt.Sprintf("Do you want to Quartz fix file: %s (y/n)? ", contentPath)) {
+				goto compareAndWrite
 			}
+		}
+	}
 
-			return nil
+	return nil
 
-		compareAndWrite:
-			buf := bytes.NewBuffer(nil)
-			fmt.Fprintln(buf, "---")
-			if err := yaml.NewEncoder(buf).Encode(matter); err != nil {
-				return err
-			}
-			fmt.Fprintln(buf, "---")
-			content = bytes.TrimSpace(content)
-			buf.Write(content)
-			buf.WriteRune('\n')
+compareAndWrite:
+	buf := bytes.NewBuffer(nil)
+	if err := encodeFrontMatter(buf, matter, cfg.format); err != nil {
+		return err
+	}
+	content = bytes.TrimSpace(content)
+	buf.Write(content)
+	buf.WriteRune('\n')
 
-			original, err := os.ReadFile(fpath)
-			if err != nil {
-				return err
-			}
+	original, err := os.ReadFile(fpath)
+	if err != nil {
+		return err
+	}
 
-			writeFpath := path.Join(target, contentPath)
-			writePath, _ := path.Split(writeFpath)
-			if err := os.MkdirAll(writePath, 509); err != nil {
-				return err
-			}
+	writeFpath := path.Join(cfg.target, contentPath)
+	writePath, _ := path.Split(writeFpath)
+	if err := os.MkdirAll(writePath, 509); err != nil {
+		return err
+	}
+
+	if bytes.Compare(buf.Bytes(), original) != 0 {
+		logf("Writing changed file: %s\n", contentPath)
+		return os.WriteFile(writeFpath, buf.Bytes(), info.Mode())
+	}
+	if always {
+		logf("Writing file with original content: %s\n", contentPath)
+		return os.WriteFile(writeFpath, original, info.Mode())
+	}
+	if cfg.debug {
+		logf("Skipping writing file: %s\n", contentPath)
+	}
+
+	return nil
+}
 
-			if bytes.Compare(buf.Bytes(), original) != 0 {
-				fmt.Printf("Writing changed file: %s\n", contentPath)
-				return os.WriteFile(writeFpath, buf.Bytes(), info.Mode())
+// gitIndex holds first-seen (created) and last-seen (lastmod) commit times per
+// repo-relative path, built once via buildGitIndex instead of a per-file `git log` exec.
+type gitIndex struct {
+	created map[string]time.Time
+	lastmod map[string]time.Time
+}
+
+// buildGitIndex runs a single `git log` over the whole repo and derives created/lastmod
+// times for every path that has ever been added, modified or renamed. Renames are chained
+// so a file's created time survives across its name history.
+func buildGitIndex(wd string) (*gitIndex, error) {
+	cmd := exec.Command("git", "-C", wd, "log", "--reverse", "--name-status", "--diff-filter=AMR", "--pretty=format:commit%x09%H%x09%ci")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &gitIndex{created: make(map[string]time.Time), lastmod: make(map[string]time.Time)}
+
+	var commitTime time.Time
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "commit\t") {
+			fields := strings.SplitN(line, "\t", 3)
+			if len(fields) != 3 {
+				continue
 			}
-			if always {
-				fmt.Printf("Writing file with original content: %s\n", contentPath)
-				return os.WriteFile(writeFpath, original, info.Mode())
+			commitTime, err = time.Parse("2006-01-02 15:04:05 -0700", fields[2])
+			if err != nil {
+				return nil, err
 			}
-			if debug {
-				fmt.Printf("Skipping writing file: %s\n", contentPath)
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		status, paths := fields[0], fields[1:]
+		if strings.HasPrefix(status, "R") {
+			if len(paths) != 2 {
+				continue
 			}
+			oldPath, newPath := "/"+paths[0], "/"+paths[1]
+			if created, ok := idx.created[oldPath]; ok {
+				idx.created[newPath] = created
+				delete(idx.created, oldPath)
+			} else {
+				idx.created[newPath] = commitTime
+			}
+			delete(idx.lastmod, oldPath)
+			idx.lastmod[newPath] = commitTime
+			continue
+		}
 
-			return nil
-		},
-	); err != nil {
-		panic(err)
+		if len(paths) != 1 {
+			continue
+		}
+		p := "/" + paths[0]
+		if _, ok := idx.created[p]; !ok {
+			idx.created[p] = commitTime
+		}
+		idx.lastmod[p] = commitTime
+	}
+
+	return idx, scanner.Err()
+}
+
+// getCreated returns the created time for contentPath, falling back to a per-file
+// `git log` exec when the index wasn't built or doesn't know about the path (e.g. untracked).
+func (idx *gitIndex) getCreated(contentPath, fpath string) (time.Time, error) {
+	if idx != nil {
+		if t, ok := idx.created[contentPath]; ok {
+			return t, nil
+		}
+	}
+	return getGitCreated(fpath)
+}
+
+// getLastMod returns the last-modified time for contentPath, falling back to a per-file
+// `git log` exec when the index wasn't built or doesn't know about the path (e.g. untracked).
+func (idx *gitIndex) getLastMod(contentPath, fpath string) (time.Time, error) {
+	if idx != nil {
+		if t, ok := idx.lastmod[contentPath]; ok {
+			return t, nil
+		}
 	}
+	return getGitLastMod(fpath)
 }
 
 func getGitLastMod(path string) (time.Time, error) {
@@ -288,6 +672,258 @@ func getGitCreated(path string) (time.Time, error) {
 	return time.Parse("2006-01-02 15:04:05 -0700", strings.TrimSpace(string(b)))
 }
 
+// wikilinkRe matches Obsidian `[[Target]]`, `[[Target|label]]`, `[[Target#heading]]`
+// and, with the leading `!`, `![[Target]]` embeds.
+var wikilinkRe = regexp.MustCompile(`(!?)\[\[([^\]|#]+)(#\^?[^\]|]+)?(?:\|([^\]]+))?\]\]`)
+
+// buildVaultIndex walks the vault once, collecting a filename (and alias) index used to
+// resolve `[[wikilinks]]` and a filename index of the copy-only files (by default those
+// under `/assets`) used to resolve `![[embeds]]`.
+func buildVaultIndex(wd string, excludes, copyOnly []string) (notes map[string]string, assets map[string]string, err error) {
+	notes = make(map[string]string)
+	assets = make(map[string]string)
+
+	err = filepath.Walk(wd, func(fpath string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		contentPath := strings.TrimPrefix(fpath, wd)
+		relPath := strings.TrimPrefix(contentPath, "/")
+		if matchAny(excludes, relPath) {
+			return nil
+		}
+		if matchAny(copyOnly, relPath) {
+			assets[info.Name()] = contentPath
+			return nil
+		}
+		if !strings.HasSuffix(info.Name(), ".md") {
+			return nil
+		}
+
+		matterIn, _, err := getFrontMatterIn(fpath)
+		if err != nil {
+			return err
+		}
+
+		stem := strings.TrimSuffix(info.Name(), ".md")
+		notes[stem] = contentPath
+		for _, alias := range matterIn.Aliases {
+			notes[alias] = contentPath
+		}
+
+		return nil
+	})
+
+	return notes, assets, err
+}
+
+// rewriteWikilinks substitutes Obsidian `[[wikilinks]]` and `![[embeds]]` with standard
+// Markdown links and images, resolving targets against notes and assets. Note embeds are
+// transcluded inline (see transclude); unresolvable links are left intact and, when debug
+// is on, logged against contentPath.
+func rewriteWikilinks(content []byte, wd string, notes, assets map[string]string, contentPath string, debug bool, headingOffset int) []byte {
+	return rewriteWikilinksVisited(content, wd, notes, assets, contentPath, debug, headingOffset, map[string]bool{contentPath: true})
+}
+
+func rewriteWikilinksVisited(content []byte, wd string, notes, assets map[string]string, contentPath string, debug bool, headingOffset int, visited map[string]bool) []byte {
+	return wikilinkRe.ReplaceAllFunc(content, func(match []byte) []byte {
+		groups := wikilinkRe.FindSubmatch(match)
+		embed := len(groups[1]) > 0
+		target := string(groups[2])
+		heading := strings.TrimPrefix(string(groups[3]), "#")
+		label := string(groups[4])
+
+		if embed {
+			if assetPath, ok := assets[target]; ok {
+				return []byte(fmt.Sprintf("![%s](%s)", target, assetPath))
+			}
+			if notePath, ok := notes[target]; ok {
+				return transclude(wd, notes, assets, notePath, heading, contentPath, debug, headingOffset, visited, match)
+			}
+			if debug {
+				logf("Unresolved embed %q in %s\n", target, contentPath)
+			}
+			return match
+		}
+
+		resolved, ok := notes[target]
+		if !ok {
+			if debug {
+				logf("Unresolved link %q in %s\n", target, contentPath)
+			}
+			return match
+		}
+		resolved = strings.TrimSuffix(resolved, ".md")
+		if heading != "" {
+			resolved += "#" + slugify(heading)
+		}
+		if label == "" {
+			label = target
+		}
+
+		return []byte(fmt.Sprintf("[%s](%s)", label, resolved))
+	})
+}
+
+// transclude inlines a note, heading section or block referenced by `![[Target]]`,
+// `![[Target#Heading]]` or `![[Target#^blockid]]`. headingOffset (see -transclude-heading-offset)
+// is added to every heading level of the spliced-in section (capped at 6); the heading
+// level around the transclusion in the including document is not taken into account. transclude leaves
+// the original syntax (plus a debug warning) in place on a cycle, a missing section, or an
+// unpublished target.
+func transclude(wd string, notes, assets map[string]string, notePath, rawHeading, fromPath string, debug bool, headingOffset int, visited map[string]bool, match []byte) []byte {
+	if visited[notePath] {
+		if debug {
+			logf("Transclusion cycle detected: %s -> %s\n", fromPath, notePath)
+		}
+		return match
+	}
+
+	matterIn, body, err := getFrontMatterIn(path.Join(wd, notePath))
+	if err != nil {
+		if debug {
+			logf("Could not read transclusion target %s in %s: %v\n", notePath, fromPath, err)
+		}
+		return match
+	}
+	if !matterIn.Publish {
+		if debug {
+			logf("Not transcluding unpublished note %s in %s\n", notePath, fromPath)
+		}
+		return match
+	}
+
+	var section []byte
+	var ok bool
+	switch {
+	case rawHeading == "":
+		section, ok = bytes.TrimSpace(body), true
+	case strings.HasPrefix(rawHeading, "^"):
+		section, ok = extractBlock(body, strings.TrimPrefix(rawHeading, "^"))
+	default:
+		section, ok = extractHeadingSection(body, rawHeading)
+	}
+	if !ok {
+		if debug {
+			logf("Transclusion target %q not found in %s\n", rawHeading, notePath)
+		}
+		return match
+	}
+
+	nestedVisited := make(map[string]bool, len(visited)+1)
+	for p := range visited {
+		nestedVisited[p] = true
+	}
+	nestedVisited[notePath] = true
+	section = rewriteWikilinksVisited(section, wd, notes, assets, notePath, debug, headingOffset, nestedVisited)
+
+	return shiftHeadings(section, headingOffset)
+}
+
+var headingLineRe = regexp.MustCompile(`^(#{1,6})(\s+.*)$`)
+
+// fenceLineRe matches a fenced-code-block delimiter (``` or ~~~, optionally indented up to
+// 3 spaces per CommonMark). Lines inside a fence are never heading lines, even if they
+// start with '#' (e.g. a Python comment).
+var fenceLineRe = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+
+// extractHeadingSection returns the lines from the heading slugifying to `heading` up to
+// (but not including) the next heading of the same or shallower level. Lines inside fenced
+// code blocks are never treated as headings.
+func extractHeadingSection(body []byte, heading string) ([]byte, bool) {
+	lines := strings.Split(string(body), "\n")
+	inFence := false
+	for i, line := range lines {
+		if fenceLineRe.MatchString(line) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+
+		m := headingLineRe.FindStringSubmatch(line)
+		if m == nil || slugify(strings.TrimSpace(m[2])) != slugify(heading) {
+			continue
+		}
+		level := len(m[1])
+		end := len(lines)
+		innerFence := false
+		for j := i + 1; j < len(lines); j++ {
+			if fenceLineRe.MatchString(lines[j]) {
+				innerFence = !innerFence
+				continue
+			}
+			if innerFence {
+				continue
+			}
+			if m2 := headingLineRe.FindStringSubmatch(lines[j]); m2 != nil && len(m2[1]) <= level {
+				end = j
+				break
+			}
+		}
+		return []byte(strings.Join(lines[i:end], "\n")), true
+	}
+	return nil, false
+}
+
+// extractBlock returns the single line carrying the Obsidian `^blockid` anchor, with the
+// anchor itself stripped.
+func extractBlock(body []byte, blockID string) ([]byte, bool) {
+	marker := "^" + blockID
+	for _, line := range strings.Split(string(body), "\n") {
+		if strings.Contains(line, marker) {
+			return []byte(strings.TrimSpace(strings.Replace(line, marker, "", 1))), true
+		}
+	}
+	return nil, false
+}
+
+// shiftHeadings bumps every Markdown heading in body by offset levels (capped at the
+// maximum heading depth) so a spliced-in section nests under the including document.
+// Lines inside fenced code blocks are left untouched.
+func shiftHeadings(body []byte, offset int) []byte {
+	lines := strings.Split(string(body), "\n")
+	inFence := false
+	for i, line := range lines {
+		if fenceLineRe.MatchString(line) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+
+		m := headingLineRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		level := len(m[1]) + offset
+		if level > 6 {
+			level = 6
+		}
+		lines[i] = strings.Repeat("#", level) + m[2]
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+var slugifyRe = regexp.MustCompile(`[^a-z0-9\-]+`)
+
+// slugify mirrors Quartz's heading-anchor slugs: lowercase, spaces turned into hyphens,
+// everything else that isn't alphanumeric or a hyphen stripped.
+func slugify(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.ReplaceAll(s, " ", "-")
+	return slugifyRe.ReplaceAllString(s, "")
+}
+
+// getFrontMatterIn reads and parses a note's frontmatter. frontmatter.Parse auto-detects
+// the incoming delimiter (`---` YAML, `+++` TOML, or a leading `{` JSON object), so a
+// vault can mix formats, or be migrated between them via -format, note by note.
 func getFrontMatterIn(path string) (MatterIn, []byte, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -303,3 +939,32 @@ func getFrontMatterIn(path string) (MatterIn, []byte, error) {
 
 	return matter, rest, nil
 }
+
+// encodeFrontMatter writes matter to buf using the requested frontmatter encoding,
+// including its delimiters: `---` for YAML, `+++` for TOML, or a bare JSON object.
+func encodeFrontMatter(buf *bytes.Buffer, matter any, format string) error {
+	switch format {
+	case "toml":
+		fmt.Fprintln(buf, "+++")
+		if err := toml.NewEncoder(buf).Encode(matter); err != nil {
+			return err
+		}
+		fmt.Fprintln(buf, "+++")
+	case "json":
+		enc := json.NewEncoder(buf)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(matter); err != nil {
+			return err
+		}
+		// adrg/frontmatter only recognizes a bare JSON object as frontmatter when it's
+		// followed by a blank line; without it the next read can't find where it ends.
+		buf.WriteString("\n")
+	default:
+		fmt.Fprintln(buf, "---")
+		if err := yaml.NewEncoder(buf).Encode(matter); err != nil {
+			return err
+		}
+		fmt.Fprintln(buf, "---")
+	}
+	return nil
+}
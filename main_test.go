@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+// TestGlobToRegexpDoubleStar confirms the double-star trick: `**` adjoining a literal `/`
+// on a whole path segment makes that `/` optional, so `**/Daily/**` matches both a
+// top-level `Daily/` folder and one nested under another directory.
+func TestGlobToRegexpDoubleStar(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"**/Daily/**", "Daily/x.md", true},
+		{"**/Daily/**", "a/Daily/x.md", true},
+		{"**/Daily/**", "Daily/nested/x.md", true},
+		{"**/Daily/**", "NotDaily/x.md", false},
+		{"templates/**", "templates/x.md", true},
+		{"templates/**", "templates/nested/x.md", true},
+		{"templates/**", "other/x.md", false},
+		{"static/**.pdf", "static/a/b.pdf", true},
+		{"static/**.pdf", "static/b.pdf", true},
+		{"static/**.pdf", "static/b.txt", false},
+	}
+	for _, c := range cases {
+		if got := globToRegexp(c.pattern).MatchString(c.path); got != c.want {
+			t.Errorf("globToRegexp(%q).MatchString(%q) = %v, want %v", c.pattern, c.path, got, c.want)
+		}
+	}
+}